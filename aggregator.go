@@ -0,0 +1,301 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	statsd "github.com/DataDog/datadog-go/statsd"
+	log "github.com/Sirupsen/logrus"
+)
+
+const defaultAggregationMaxSeries = 10000
+
+// bucketKind is how a metricBucket combines repeated writes within a
+// window, mirroring Telegraf's RunningAggregator semantics.
+type bucketKind int
+
+const (
+	bucketGauge bucketKind = iota
+	bucketCount
+	bucketHistogram
+	bucketDistribution
+)
+
+// metricBucket accumulates every write for one (name, tag set) pair seen
+// during the current window.
+type metricBucket struct {
+	kind    bucketKind
+	name    string
+	tags    []string
+	value   float64 // gauge: last write. count: running total.
+	min     float64
+	max     float64
+	sum     float64
+	samples int64
+}
+
+// windowAggregator buffers Gauge/Count/Histogram/Distribution calls into
+// rolling time windows and drains them to next period-by-period, instead
+// of writing through on every call. This cuts UDP/HTTP volume drastically
+// when the drain fronts many high-traffic apps.
+//
+// MetricSink carries no per-metric timestamp (it mirrors datadog-go's own
+// Gauge/Count/Histogram/Distribution signatures), so windows are tracked
+// by arrival time, not by when a metric was generated: a window is
+// flushed `delay` after periodEnd rather than exactly at periodEnd, so
+// writes that arrive up to `delay` late still land in the bucket before
+// it's sent, and `grace` extends the window's start back by `grace`
+// to cover writes landing right at a rotation under concurrent
+// goroutines. This is Telegraf's RunningAggregator shape applied to
+// arrival time rather than event time. Events are not buffered: they're
+// discrete and time-sensitive, so they pass straight through to next.
+type windowAggregator struct {
+	next      MetricSink
+	period    time.Duration
+	delay     time.Duration
+	grace     time.Duration
+	maxSeries int
+
+	mu          sync.Mutex
+	periodStart time.Time
+	periodEnd   time.Time
+	buckets     map[string]*metricBucket
+
+	stop chan struct{}
+}
+
+func newWindowAggregator(next MetricSink, period, delay, grace time.Duration, maxSeries int) *windowAggregator {
+	if maxSeries <= 0 {
+		maxSeries = defaultAggregationMaxSeries
+	}
+	now := time.Now()
+	a := &windowAggregator{
+		next:        next,
+		period:      period,
+		delay:       delay,
+		grace:       grace,
+		maxSeries:   maxSeries,
+		periodStart: now,
+		periodEnd:   now.Add(period),
+		buckets:     make(map[string]*metricBucket),
+		stop:        make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// newAggregatorFromEnv builds a windowAggregator for c from
+// AGGREGATION_PERIOD/AGGREGATION_DELAY/AGGREGATION_GRACE/
+// AGGREGATION_MAX_SERIES, flushing into c's statsd/HTTP sinks.
+func newAggregatorFromEnv(c *Client, periodRaw string) (*windowAggregator, error) {
+	period, err := time.ParseDuration(periodRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	delay, err := parseDurationEnv("AGGREGATION_DELAY", 0)
+	if err != nil {
+		return nil, err
+	}
+	grace, err := parseDurationEnv("AGGREGATION_GRACE", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	maxSeries := defaultAggregationMaxSeries
+	if raw := os.Getenv("AGGREGATION_MAX_SERIES"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, err
+		}
+		maxSeries = parsed
+	}
+
+	log.WithFields(log.Fields{
+		"period": period, "delay": delay, "grace": grace, "maxSeries": maxSeries,
+	}).Info("Metric aggregation window enabled")
+
+	return newWindowAggregator(&directSink{c}, period, delay, grace, maxSeries), nil
+}
+
+func parseDurationEnv(name string, def time.Duration) (time.Duration, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	return time.ParseDuration(raw)
+}
+
+func (a *windowAggregator) run() {
+	ticker := time.NewTicker(a.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.scheduleFlush()
+		case <-a.stop:
+			a.flush()
+			return
+		}
+	}
+}
+
+// scheduleFlush drains the current window after `delay` has passed
+// rather than immediately, so writes landing up to `delay` after
+// periodEnd still make it into the bucket before it's sent.
+func (a *windowAggregator) scheduleFlush() {
+	if a.delay <= 0 {
+		a.flush()
+		return
+	}
+	time.AfterFunc(a.delay, a.flush)
+}
+
+// Close flushes any buffered metrics and stops the aggregator's ticker.
+func (a *windowAggregator) Close() error {
+	close(a.stop)
+	return nil
+}
+
+func bucketKey(name string, tags []string) string {
+	sorted := append([]string(nil), tags...)
+	sort.Strings(sorted)
+	return name + "|" + strings.Join(sorted, ",")
+}
+
+// withinWindow reports whether now falls inside [periodStart-grace,
+// periodEnd+delay]. Call with a.mu held.
+func (a *windowAggregator) withinWindow(now time.Time) bool {
+	return !now.Before(a.periodStart.Add(-a.grace)) && !now.After(a.periodEnd.Add(a.delay))
+}
+
+func (a *windowAggregator) bucketFor(name string, tags []string, kind bucketKind) *metricBucket {
+	key := bucketKey(name, tags)
+	b, ok := a.buckets[key]
+	if ok {
+		return b
+	}
+	if len(a.buckets) >= a.maxSeries {
+		log.WithFields(log.Fields{
+			"metric": name, "maxSeries": a.maxSeries,
+		}).Warn("Aggregation series cap reached, dropping metric")
+		return nil
+	}
+	b = &metricBucket{kind: kind, name: name, tags: tags}
+	a.buckets[key] = b
+	return b
+}
+
+func (a *windowAggregator) Gauge(name string, value float64, tags []string, rate float64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.withinWindow(time.Now()) {
+		return nil
+	}
+	if b := a.bucketFor(name, tags, bucketGauge); b != nil {
+		b.value = value
+	}
+	return nil
+}
+
+func (a *windowAggregator) Count(name string, value int64, tags []string, rate float64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.withinWindow(time.Now()) {
+		return nil
+	}
+	if b := a.bucketFor(name, tags, bucketCount); b != nil {
+		b.value += float64(value)
+	}
+	return nil
+}
+
+func (a *windowAggregator) addSample(name string, value float64, tags []string, kind bucketKind) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.withinWindow(time.Now()) {
+		return
+	}
+	b := a.bucketFor(name, tags, kind)
+	if b == nil {
+		return
+	}
+	if b.samples == 0 || value < b.min {
+		b.min = value
+	}
+	if b.samples == 0 || value > b.max {
+		b.max = value
+	}
+	b.sum += value
+	b.samples++
+}
+
+func (a *windowAggregator) Histogram(name string, value float64, tags []string, rate float64) error {
+	a.addSample(name, value, tags, bucketHistogram)
+	return nil
+}
+
+func (a *windowAggregator) Distribution(name string, value float64, tags []string, rate float64) error {
+	a.addSample(name, value, tags, bucketDistribution)
+	return nil
+}
+
+// Event passes straight through: events are discrete and tied to a
+// specific moment, so they aren't a good fit for window aggregation.
+func (a *windowAggregator) Event(e *statsd.Event) error {
+	return a.next.Event(e)
+}
+
+func (a *windowAggregator) flush() {
+	a.mu.Lock()
+	buckets := a.buckets
+	a.buckets = make(map[string]*metricBucket)
+	a.periodStart = a.periodEnd
+	a.periodEnd = a.periodStart.Add(a.period)
+	a.mu.Unlock()
+
+	for _, b := range buckets {
+		a.emit(b)
+	}
+}
+
+// emit drains one bucket to next. Counts and gauges collapse to a single
+// write; histograms/distributions emit the window's mean as a single
+// sample (through the same method the samples arrived on) plus the
+// retained min/max/count as suffixed gauges, so dashboards built on the
+// pre-aggregated stats keep working without the full sample set.
+func (a *windowAggregator) emit(b *metricBucket) {
+	var err error
+	switch b.kind {
+	case bucketGauge:
+		err = a.next.Gauge(b.name, b.value, b.tags, sampleRate)
+	case bucketCount:
+		err = a.next.Count(b.name, int64(b.value), b.tags, sampleRate)
+	case bucketHistogram:
+		err = a.next.Histogram(b.name, b.sum/float64(b.samples), b.tags, sampleRate)
+		a.emitSummary(b)
+	case bucketDistribution:
+		err = a.next.Distribution(b.name, b.sum/float64(b.samples), b.tags, sampleRate)
+		a.emitSummary(b)
+	}
+	if err != nil {
+		log.WithField("error", err).Info("Failed to flush aggregated metric")
+	}
+}
+
+func (a *windowAggregator) emitSummary(b *metricBucket) {
+	if err := a.next.Gauge(b.name+".min", b.min, b.tags, sampleRate); err != nil {
+		log.WithField("error", err).Info("Failed to flush aggregated metric")
+	}
+	if err := a.next.Gauge(b.name+".max", b.max, b.tags, sampleRate); err != nil {
+		log.WithField("error", err).Info("Failed to flush aggregated metric")
+	}
+	if err := a.next.Count(b.name+".count", b.samples, b.tags, sampleRate); err != nil {
+		log.WithField("error", err).Info("Failed to flush aggregated metric")
+	}
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"errors"
+	"os"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	statsd "github.com/DataDog/datadog-go/statsd"
 	log "github.com/Sirupsen/logrus"
@@ -20,15 +22,22 @@ const (
 	pgSampleMsg
 	redisSampleMsg
 	releaseMsg
+	dynoErrorMsg
 )
 
 var routerMetricsKeys = []string{"dyno", "method", "status", "host", "code", "desc", "at"}
 var sampleMetricsKeys = []string{"source", "dyno"}
 var scalingMetricsKeys = []string{"mailer", "web"}
+var dynoErrorMetricsKeys = []string{"dyno", "code", "desc"}
 
 type Client struct {
 	*statsd.Client
-	ExcludedTags map[string]bool
+	ExcludedTags     map[string]bool
+	sinks            []MetricSink
+	UseDistributions bool
+	aggregator       MetricSink
+	rules            *ruleSet
+	errorCodes       map[string]herokuErrorInfo
 }
 
 var statusCode *regexp.Regexp = regexp.MustCompile(`^(?P<Family>\d)\d\d`)
@@ -36,7 +45,124 @@ var statusCode *regexp.Regexp = regexp.MustCompile(`^(?P<Family>\d)\d\d`)
 func statsdClient(addr string) (*Client, error) {
 
 	c, err := statsd.New(addr)
-	return &Client{c, make(map[string]bool)}, err
+	client := &Client{
+		Client:           c,
+		ExcludedTags:     make(map[string]bool),
+		UseDistributions: os.Getenv("USE_DISTRIBUTIONS") == "true",
+		errorCodes:       loadHerokuErrorCodes(),
+	}
+
+	if apiKey := os.Getenv("DD_API_KEY"); apiKey != "" {
+		flushInterval := defaultFlushInterval
+		if raw := os.Getenv("DD_FLUSH_INTERVAL"); raw != "" {
+			if parsed, err := time.ParseDuration(raw); err == nil {
+				flushInterval = parsed
+			} else {
+				log.WithField("error", err).Warn("Invalid DD_FLUSH_INTERVAL, using default")
+			}
+		}
+		client.sinks = append(client.sinks, newHTTPSink(apiKey, os.Getenv("DD_SITE"), flushInterval))
+		log.WithField("site", os.Getenv("DD_SITE")).Info("Datadog HTTP API sink enabled")
+	}
+
+	if period := os.Getenv("AGGREGATION_PERIOD"); period != "" {
+		aggregator, aggErr := newAggregatorFromEnv(client, period)
+		if aggErr != nil {
+			log.WithField("error", aggErr).Warn("Invalid aggregation window config, aggregation disabled")
+		} else {
+			client.aggregator = aggregator
+		}
+	}
+
+	rules, rulesErr := loadRuleSetFromEnv()
+	if rulesErr != nil {
+		log.WithField("error", rulesErr).Warn("Failed to load custom rule config, custom metric rules disabled")
+	} else {
+		client.rules = rules
+	}
+
+	return client, err
+}
+
+// directSink writes straight through to the statsd client and any extra
+// configured sinks, bypassing the aggregator. It's the aggregator's own
+// flush target, and what Client falls back to when aggregation is off.
+type directSink struct{ c *Client }
+
+func (d *directSink) Gauge(name string, value float64, tags []string, rate float64) error {
+	return d.c.writeThrough(func(s MetricSink) error { return s.Gauge(name, value, tags, rate) })
+}
+
+func (d *directSink) Count(name string, value int64, tags []string, rate float64) error {
+	return d.c.writeThrough(func(s MetricSink) error { return s.Count(name, value, tags, rate) })
+}
+
+func (d *directSink) Histogram(name string, value float64, tags []string, rate float64) error {
+	return d.c.writeThrough(func(s MetricSink) error { return s.Histogram(name, value, tags, rate) })
+}
+
+func (d *directSink) Distribution(name string, value float64, tags []string, rate float64) error {
+	return d.c.writeThrough(func(s MetricSink) error { return s.Distribution(name, value, tags, rate) })
+}
+
+func (d *directSink) Event(e *statsd.Event) error {
+	return d.c.writeThrough(func(s MetricSink) error { return s.Event(e) })
+}
+
+// writeThrough runs f against the statsd client and every additional
+// configured sink (e.g. the Datadog HTTP API), returning the statsd
+// client's error so existing call sites keep seeing the same behaviour
+// as before.
+func (c *Client) writeThrough(f func(MetricSink) error) error {
+	err := f(c.Client)
+	for _, s := range c.sinks {
+		if sinkErr := f(s); sinkErr != nil {
+			log.WithField("error", sinkErr).Info("Failed to write metric to sink")
+		}
+	}
+	return err
+}
+
+// downstream returns where Gauge/Count/Histogram/Distribution/Event calls
+// should go: the aggregation window if one is configured, otherwise
+// straight through to the sinks.
+func (c *Client) downstream() MetricSink {
+	if c.aggregator != nil {
+		return c.aggregator
+	}
+	return &directSink{c}
+}
+
+func (c *Client) Gauge(name string, value float64, tags []string, rate float64) error {
+	return c.downstream().Gauge(name, value, tags, rate)
+}
+
+func (c *Client) Count(name string, value int64, tags []string, rate float64) error {
+	return c.downstream().Count(name, value, tags, rate)
+}
+
+func (c *Client) Histogram(name string, value float64, tags []string, rate float64) error {
+	return c.downstream().Histogram(name, value, tags, rate)
+}
+
+func (c *Client) Distribution(name string, value float64, tags []string, rate float64) error {
+	return c.downstream().Distribution(name, value, tags, rate)
+}
+
+func (c *Client) Event(e *statsd.Event) error {
+	return c.downstream().Event(e)
+}
+
+// histogramOrDistribution sends value as a Distribution when the client is
+// configured to use them (USE_DISTRIBUTIONS=true), so percentiles are
+// computed globally on the Datadog side instead of being averaged across
+// each agent's own per-host percentiles. Otherwise it falls back to the
+// existing per-host Histogram behaviour.
+func (c *Client) histogramOrDistribution(name string, value float64, tags []string, rate float64) error {
+	if c.UseDistributions {
+		return c.Distribution(name, value, tags, rate)
+	}
+	return c.Histogram(name, value, tags, rate)
 }
 
 func (c *Client) sendToStatsd(in chan *logMetrics) {
@@ -70,16 +196,29 @@ func (c *Client) sendToStatsd(in chan *logMetrics) {
 			c.sendScalingMsg(data)
 		} else if data.typ == releaseMsg {
 			c.sendEvents(*data.app, "app", data.events, *data.tags)
+		} else if data.typ == dynoErrorMsg {
+			c.sendDynoErrorMsg(data)
+		} else if name, metricType, value, tags, matched := c.rules.match(reconstructLogLine(data.metrics)); matched {
+			err := c.sendMetric(metricType, *data.prefix+name, value, tags)
+			if err != nil {
+				log.WithField("error", err).Info("Failed to send rule-derived metric")
+			}
 		} else {
 			log.WithField("type", data.typ).Warn("Unknown log message")
 		}
 	}
 }
 
-func (c *Client) sendEvents(app string, namespace string, events []string, tags []string) {
+// sendEvents sends one Datadog event per entry in events. alertType is
+// optional and, when given, overrides the event's default alert type
+// (e.g. "error" for severe Heroku platform errors).
+func (c *Client) sendEvents(app string, namespace string, events []string, tags []string, alertType ...string) {
 	for _, v := range events {
 		event := statsd.NewEvent(namespace+"/api: "+app, v)
 		event.Tags = tags
+		if len(alertType) > 0 && alertType[0] != "" {
+			event.AlertType = statsd.EventAlertType(alertType[0])
+		}
 		c.Event(event)
 		log.WithFields(log.Fields{
 			"type":  "event",
@@ -89,6 +228,58 @@ func (c *Client) sendEvents(app string, namespace string, events []string, tags
 	}
 }
 
+// appendTagIfMissing returns tags with prefix+value appended, unless tags
+// already has an entry starting with prefix or the tag key (prefix minus
+// its trailing ":") is excluded via Client.ExcludedTags.
+func (c *Client) appendTagIfMissing(tags []string, prefix string, value string) []string {
+	if c.ExcludedTags[strings.TrimSuffix(prefix, ":")] {
+		return tags
+	}
+	for _, t := range tags {
+		if strings.HasPrefix(t, prefix) {
+			return tags
+		}
+	}
+	return append(append([]string{}, tags...), prefix+value)
+}
+
+// sendPlatformError emits a tagged heroku.platform.error counter for a
+// Heroku H/R error code, plus a Datadog event for codes whose severity
+// is "error" (see errorCodes), using sendEvents.
+func (c *Client) sendPlatformError(app string, prefix string, tags []string, code string) {
+	errTags := c.appendTagIfMissing(tags, "code:", code)
+	errTags = c.appendTagIfMissing(errTags, "errorFamily:", errorFamily(code))
+	sort.Strings(errTags)
+
+	err := c.Count(prefix+"heroku.platform.error", 1, errTags, sampleRate)
+	if err != nil {
+		log.WithField("error", err).Info("Failed to send Count")
+	}
+
+	info, known := c.errorCodes[code]
+	if known && info.Severity == "error" {
+		c.sendEvents(app, "heroku", []string{code + ": " + info.Description}, errTags, "error")
+	}
+}
+
+func (c *Client) sendDynoErrorMsg(data *logMetrics) {
+	tags := c.extractTags(*data.tags, dynoErrorMetricsKeys, data.metrics)
+
+	log.WithFields(log.Fields{
+		"app":    *data.app,
+		"tags":   tags,
+		"prefix": *data.prefix,
+	}).Debug("sendDynoErrorMsg")
+
+	code, ok := data.metrics["code"]
+	if !ok || code.Val == "" {
+		log.Warn("dynoErrorMsg without a code, dropping")
+		return
+	}
+
+	c.sendPlatformError(*data.app, *data.prefix, tags, code.Val)
+}
+
 func (c *Client) extractTags(tags []string, permittedTags []string, metrics map[string]logValue) []string {
 	for _, mk := range permittedTags {
 		if c.ExcludedTags[mk] {
@@ -151,15 +342,15 @@ func (c *Client) sendRouterMsg(data *logMetrics) {
 		return
 	}
 	// https://devcenter.heroku.com/articles/http-routing
-	err = c.Histogram(*data.prefix+"heroku.router.response.bytes", bytes, tags, sampleRate)
+	err = c.histogramOrDistribution(*data.prefix+"heroku.router.response.bytes", bytes, tags, sampleRate)
 	if err != nil {
 		log.WithField("error", err).Info("Failed to send Histogram")
 	}
-	err = c.Histogram(*data.prefix+"heroku.router.request.connect", conn, tags, sampleRate)
+	err = c.histogramOrDistribution(*data.prefix+"heroku.router.request.connect", conn, tags, sampleRate)
 	if err != nil {
 		log.WithField("error", err).Info("Failed to send Histogram")
 	}
-	err = c.Histogram(*data.prefix+"heroku.router.request.service", serv, tags, sampleRate)
+	err = c.histogramOrDistribution(*data.prefix+"heroku.router.request.service", serv, tags, sampleRate)
 	if err != nil {
 		log.WithField("error", err).Info("Failed to send Histogram")
 	}
@@ -168,6 +359,9 @@ func (c *Client) sendRouterMsg(data *logMetrics) {
 		if err != nil {
 			log.WithField("error", err).Info("Failed to send Count")
 		}
+		if code, ok := data.metrics["code"]; ok && code.Val != "" {
+			c.sendPlatformError(*data.app, *data.prefix, tags, code.Val)
+		}
 	}
 }
 
@@ -234,7 +428,7 @@ func (c *Client) sendMetric(metricType string, metricName string, value float64,
 	case "metric", "sample":
 		return c.Gauge(metricName, value, tags, sampleRate)
 	case "measure":
-		return c.Histogram(metricName, value, tags, sampleRate)
+		return c.histogramOrDistribution(metricName, value, tags, sampleRate)
 	case "count":
 		return c.Count(metricName, int64(value), tags, sampleRate)
 	default:
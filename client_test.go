@@ -0,0 +1,182 @@
+package main
+
+import (
+	"testing"
+
+	statsd "github.com/DataDog/datadog-go/statsd"
+)
+
+// recordedCall captures one MetricSink invocation for assertions.
+type recordedCall struct {
+	name  string
+	value float64
+	tags  []string
+}
+
+// spySink is a MetricSink that just records what it was sent, so tests
+// can assert which method was called and with which tags, without a real
+// statsd/Datadog backend.
+type spySink struct {
+	gauges        []recordedCall
+	counts        []recordedCall
+	histograms    []recordedCall
+	distributions []recordedCall
+}
+
+func (s *spySink) Gauge(name string, value float64, tags []string, rate float64) error {
+	s.gauges = append(s.gauges, recordedCall{name, value, tags})
+	return nil
+}
+
+func (s *spySink) Count(name string, value int64, tags []string, rate float64) error {
+	s.counts = append(s.counts, recordedCall{name, float64(value), tags})
+	return nil
+}
+
+func (s *spySink) Histogram(name string, value float64, tags []string, rate float64) error {
+	s.histograms = append(s.histograms, recordedCall{name, value, tags})
+	return nil
+}
+
+func (s *spySink) Distribution(name string, value float64, tags []string, rate float64) error {
+	s.distributions = append(s.distributions, recordedCall{name, value, tags})
+	return nil
+}
+
+func (s *spySink) Event(e *statsd.Event) error {
+	return nil
+}
+
+// newTestClient builds a Client backed by a real (but unconnected UDP)
+// statsd.Client plus a spySink, so tests can inspect what was written
+// without standing up a statsd/Datadog backend.
+func newTestClient(t *testing.T) (*Client, *spySink) {
+	t.Helper()
+	sc, err := statsd.New("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("statsd.New: %v", err)
+	}
+	spy := &spySink{}
+	return &Client{
+		Client:       sc,
+		ExcludedTags: make(map[string]bool),
+		sinks:        []MetricSink{spy},
+	}, spy
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func TestHistogramOrDistributionRespectsFlag(t *testing.T) {
+	c, spy := newTestClient(t)
+
+	if err := c.histogramOrDistribution("a.metric", 1, []string{"a:b"}, sampleRate); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(spy.histograms) != 1 || len(spy.distributions) != 0 {
+		t.Fatalf("expected a Histogram call with USE_DISTRIBUTIONS unset, got histograms=%d distributions=%d", len(spy.histograms), len(spy.distributions))
+	}
+
+	c.UseDistributions = true
+	if err := c.histogramOrDistribution("a.metric", 1, []string{"a:b"}, sampleRate); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(spy.distributions) != 1 {
+		t.Fatalf("expected a Distribution call once USE_DISTRIBUTIONS is set, got %d", len(spy.distributions))
+	}
+}
+
+func TestSendRouterMsgUsesDistributionsAndPreservesTags(t *testing.T) {
+	c, spy := newTestClient(t)
+	c.UseDistributions = true
+
+	app := "myapp"
+	prefix := "heroku."
+	tags := []string{"dyno:web.1"}
+	data := &logMetrics{
+		typ:    routerMsg,
+		app:    &app,
+		prefix: &prefix,
+		tags:   &tags,
+		metrics: map[string]logValue{
+			"connect": {Val: "1"},
+			"service": {Val: "2"},
+			"bytes":   {Val: "3"},
+			"status":  {Val: "200"},
+			"at":      {Val: "info"},
+		},
+	}
+
+	c.sendRouterMsg(data)
+
+	if len(spy.distributions) != 3 {
+		t.Fatalf("expected 3 Distribution calls (connect/service/bytes), got %d", len(spy.distributions))
+	}
+	if len(spy.histograms) != 0 {
+		t.Fatalf("expected no Histogram calls once USE_DISTRIBUTIONS is set, got %d", len(spy.histograms))
+	}
+	for _, d := range spy.distributions {
+		if !hasTag(d.tags, "dyno:web.1") {
+			t.Errorf("expected dyno:web.1 tag on %s, got %v", d.name, d.tags)
+		}
+		if !hasTag(d.tags, "statusFamily:2xx") {
+			t.Errorf("expected statusFamily:2xx tag on %s, got %v", d.name, d.tags)
+		}
+	}
+}
+
+func TestSendRouterMsgUsesHistogramsByDefault(t *testing.T) {
+	c, spy := newTestClient(t)
+
+	app := "myapp"
+	prefix := "heroku."
+	tags := []string{"dyno:web.1"}
+	data := &logMetrics{
+		typ:    routerMsg,
+		app:    &app,
+		prefix: &prefix,
+		tags:   &tags,
+		metrics: map[string]logValue{
+			"connect": {Val: "1"},
+			"service": {Val: "2"},
+			"bytes":   {Val: "3"},
+			"status":  {Val: "500"},
+			"at":      {Val: "info"},
+		},
+	}
+
+	c.sendRouterMsg(data)
+
+	if len(spy.histograms) != 3 {
+		t.Fatalf("expected 3 Histogram calls (connect/service/bytes) with USE_DISTRIBUTIONS unset, got %d", len(spy.histograms))
+	}
+	if len(spy.distributions) != 0 {
+		t.Fatalf("expected no Distribution calls with USE_DISTRIBUTIONS unset, got %d", len(spy.distributions))
+	}
+	for _, h := range spy.histograms {
+		if !hasTag(h.tags, "statusFamily:5xx") {
+			t.Errorf("expected statusFamily:5xx tag on %s, got %v", h.name, h.tags)
+		}
+	}
+}
+
+func TestSendMetricUsesDistributionForMeasureWhenEnabled(t *testing.T) {
+	c, spy := newTestClient(t)
+	c.UseDistributions = true
+
+	if err := c.sendMetric("measure", "custom.metric", 42, []string{"env:prod"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(spy.distributions) != 1 {
+		t.Fatalf("expected sendMetric(\"measure\", ...) to use Distribution, got %d", len(spy.distributions))
+	}
+	if !hasTag(spy.distributions[0].tags, "env:prod") {
+		t.Errorf("expected env:prod tag preserved, got %v", spy.distributions[0].tags)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import "regexp"
+
+// dynoErrorLine matches the dyno-manager error lines Heroku emits
+// directly on an app's log stream, e.g.:
+//   heroku[web.1]: Error R14 (Memory quota exceeded)
+// This is how R-codes (R10, R14, R15, ...) reach the drain; unlike
+// H-codes they never appear in a router log line's `code=` field.
+var dynoErrorLine = regexp.MustCompile(`Error (?P<code>[HR]\d+) \((?P<desc>[^)]*)\)`)
+
+// parseDynoErrorLine extracts the Heroku error code and description from
+// a raw dyno-manager log line. ok is false for lines that aren't a dyno
+// error (the common case - most app log lines won't match).
+func parseDynoErrorLine(line string) (code string, desc string, ok bool) {
+	m := dynoErrorLine.FindStringSubmatch(line)
+	if m == nil {
+		return "", "", false
+	}
+	for i, name := range dynoErrorLine.SubexpNames() {
+		switch name {
+		case "code":
+			code = m[i]
+		case "desc":
+			desc = m[i]
+		}
+	}
+	return code, desc, true
+}
+
+// newDynoErrorMetrics builds the *logMetrics the log-line classifier
+// should emit for a dyno-manager error line, so it reaches
+// sendDynoErrorMsg via sendToStatsd's dynoErrorMsg branch. Returns nil if
+// line isn't a dyno error line.
+func newDynoErrorMetrics(app string, prefix string, tags []string, line string) *logMetrics {
+	code, desc, ok := parseDynoErrorLine(line)
+	if !ok {
+		return nil
+	}
+	return &logMetrics{
+		typ:    dynoErrorMsg,
+		app:    &app,
+		prefix: &prefix,
+		tags:   &tags,
+		metrics: map[string]logValue{
+			"code": {Val: code},
+			"desc": {Val: desc},
+		},
+	}
+}
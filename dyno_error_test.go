@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+func TestParseDynoErrorLine(t *testing.T) {
+	cases := []struct {
+		name     string
+		line     string
+		wantCode string
+		wantDesc string
+		wantOK   bool
+	}{
+		{
+			name:     "memory quota exceeded",
+			line:     "heroku[web.1]: Error R14 (Memory quota exceeded)",
+			wantCode: "R14",
+			wantDesc: "Memory quota exceeded",
+			wantOK:   true,
+		},
+		{
+			name:     "app crashed",
+			line:     "heroku[web.1]: Error H10 (App crashed)",
+			wantCode: "H10",
+			wantDesc: "App crashed",
+			wantOK:   true,
+		},
+		{
+			name:   "not a dyno error line",
+			line:   `at=info method=GET path="/" host=example.com dyno=web.1 connect=1ms service=2ms status=200 bytes=123`,
+			wantOK: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			code, desc, ok := parseDynoErrorLine(tc.line)
+			if ok != tc.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tc.wantOK)
+			}
+			if !tc.wantOK {
+				return
+			}
+			if code != tc.wantCode {
+				t.Errorf("code = %q, want %q", code, tc.wantCode)
+			}
+			if desc != tc.wantDesc {
+				t.Errorf("desc = %q, want %q", desc, tc.wantDesc)
+			}
+		})
+	}
+}
+
+func TestNewDynoErrorMetrics(t *testing.T) {
+	m := newDynoErrorMetrics("myapp", "heroku.", []string{"dyno:web.1"}, "heroku[web.1]: Error R14 (Memory quota exceeded)")
+	if m == nil {
+		t.Fatal("expected non-nil logMetrics for a matching line")
+	}
+	if m.typ != dynoErrorMsg {
+		t.Errorf("typ = %v, want dynoErrorMsg", m.typ)
+	}
+	if m.metrics["code"].Val != "R14" {
+		t.Errorf("code = %q, want R14", m.metrics["code"].Val)
+	}
+	if m.metrics["desc"].Val != "Memory quota exceeded" {
+		t.Errorf("desc = %q, want %q", m.metrics["desc"].Val, "Memory quota exceeded")
+	}
+
+	if m := newDynoErrorMetrics("myapp", "heroku.", nil, "at=info method=GET"); m != nil {
+		t.Fatalf("expected nil logMetrics for a non-matching line, got %+v", m)
+	}
+}
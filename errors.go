@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// herokuErrorInfo is a human description and severity for one Heroku
+// platform error code (H-codes from the routing layer, R-codes from the
+// dyno manager). See https://devcenter.heroku.com/articles/error-codes.
+type herokuErrorInfo struct {
+	Description string `json:"description"`
+	Severity    string `json:"severity"` // "error" or "warning"
+}
+
+// defaultHerokuErrorCodes covers the H/R codes teams hit most often.
+// Override or extend it with HEROKU_ERROR_CODES (a JSON file mapping
+// code to {"description", "severity"}) for teams that want to suppress
+// noisy codes or add their own.
+var defaultHerokuErrorCodes = map[string]herokuErrorInfo{
+	"H10": {"App crashed", "error"},
+	"H12": {"Request timeout", "error"},
+	"H13": {"Connection closed without response", "error"},
+	"H14": {"No web dynos running", "error"},
+	"H18": {"Server request interrupted", "warning"},
+	"H21": {"Backend connection refused", "error"},
+	"H22": {"Connection limit reached", "warning"},
+	"H99": {"Platform error", "error"},
+	"R10": {"Boot timeout", "error"},
+	"R12": {"Exit timeout", "warning"},
+	"R14": {"Memory quota exceeded", "warning"},
+	"R15": {"Memory quota vastly exceeded", "error"},
+	"R16": {"Detached process died", "warning"},
+	"R17": {"Checksum error", "error"},
+}
+
+// loadHerokuErrorCodes returns defaultHerokuErrorCodes, merged with any
+// overrides from the file named by HEROKU_ERROR_CODES.
+func loadHerokuErrorCodes() map[string]herokuErrorInfo {
+	path := os.Getenv("HEROKU_ERROR_CODES")
+	if path == "" {
+		return defaultHerokuErrorCodes
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.WithField("error", err).Warn("Failed to read HEROKU_ERROR_CODES, using defaults")
+		return defaultHerokuErrorCodes
+	}
+
+	var overrides map[string]herokuErrorInfo
+	if err := json.Unmarshal(raw, &overrides); err != nil {
+		log.WithField("error", err).Warn("Failed to parse HEROKU_ERROR_CODES, using defaults")
+		return defaultHerokuErrorCodes
+	}
+
+	codes := make(map[string]herokuErrorInfo, len(defaultHerokuErrorCodes)+len(overrides))
+	for k, v := range defaultHerokuErrorCodes {
+		codes[k] = v
+	}
+	for k, v := range overrides {
+		// Merge onto any default entry so a partial override (e.g. just
+		// a custom description) doesn't blank out the other field.
+		merged := codes[k]
+		if v.Description != "" {
+			merged.Description = v.Description
+		}
+		if v.Severity != "" {
+			merged.Severity = v.Severity
+		}
+		codes[k] = merged
+	}
+	return codes
+}
+
+// errorFamily returns the leading letter of a Heroku error code (H or R).
+func errorFamily(code string) string {
+	if code == "" {
+		return ""
+	}
+	return strings.ToUpper(code[:1])
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadHerokuErrorCodesMergesPartialOverride(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "codes.json")
+	// Only override the description for H10; severity should keep the
+	// default value rather than being blanked out.
+	if err := ioutil.WriteFile(path, []byte(`{"H10": {"description": "custom crash message"}}`), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	oldEnv := os.Getenv("HEROKU_ERROR_CODES")
+	os.Setenv("HEROKU_ERROR_CODES", path)
+	defer os.Setenv("HEROKU_ERROR_CODES", oldEnv)
+
+	codes := loadHerokuErrorCodes()
+
+	got, ok := codes["H10"]
+	if !ok {
+		t.Fatal("expected H10 to be present")
+	}
+	if got.Description != "custom crash message" {
+		t.Errorf("Description = %q, want %q", got.Description, "custom crash message")
+	}
+	if got.Severity != defaultHerokuErrorCodes["H10"].Severity {
+		t.Errorf("Severity = %q, want default %q (partial override must not blank it)", got.Severity, defaultHerokuErrorCodes["H10"].Severity)
+	}
+
+	// Codes not mentioned in the override file keep their defaults.
+	if codes["R14"] != defaultHerokuErrorCodes["R14"] {
+		t.Errorf("R14 = %+v, want untouched default %+v", codes["R14"], defaultHerokuErrorCodes["R14"])
+	}
+}
+
+func TestLoadHerokuErrorCodesNoOverrideFile(t *testing.T) {
+	oldEnv := os.Getenv("HEROKU_ERROR_CODES")
+	os.Setenv("HEROKU_ERROR_CODES", "")
+	defer os.Setenv("HEROKU_ERROR_CODES", oldEnv)
+
+	codes := loadHerokuErrorCodes()
+	if len(codes) != len(defaultHerokuErrorCodes) {
+		t.Fatalf("got %d codes, want %d defaults", len(codes), len(defaultHerokuErrorCodes))
+	}
+}
+
+func TestErrorFamily(t *testing.T) {
+	cases := map[string]string{
+		"H12": "H",
+		"R14": "R",
+		"":    "",
+	}
+	for code, want := range cases {
+		if got := errorFamily(code); got != want {
+			t.Errorf("errorFamily(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
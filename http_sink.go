@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	statsd "github.com/DataDog/datadog-go/statsd"
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	defaultDDSite        = "datadoghq.com"
+	defaultFlushInterval = 10 * time.Second
+	httpSinkMaxRetries   = 3
+)
+
+// point is a single (timestamp, value) sample, encoded the way the
+// Datadog series API expects: [unix_seconds, value].
+type point [2]float64
+
+// seriesMetric is one entry of a /api/v1/series payload.
+type seriesMetric struct {
+	Metric string   `json:"metric"`
+	Points []point  `json:"points"`
+	Host   string   `json:"host,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Type   string   `json:"type"`
+}
+
+type timeSeries struct {
+	Series []seriesMetric `json:"series"`
+}
+
+// ddEvent is one entry of a /api/v1/events payload.
+type ddEvent struct {
+	Title     string   `json:"title"`
+	Text      string   `json:"text"`
+	Tags      []string `json:"tags,omitempty"`
+	AlertType string   `json:"alert_type,omitempty"`
+}
+
+// httpSink buffers Gauge/Count/Histogram/Event calls and flushes them to
+// Datadog's HTTP API on a ticker, rather than through a local dogstatsd
+// agent. This is the transport of choice on Heroku, where running a
+// dogstatsd sidecar next to the drain is usually not an option.
+type httpSink struct {
+	apiKey string
+	site   string
+	host   string
+	client *http.Client
+
+	mu     sync.Mutex
+	series []seriesMetric
+	events []ddEvent
+
+	stop chan struct{}
+}
+
+func newHTTPSink(apiKey string, site string, flushInterval time.Duration) *httpSink {
+	if site == "" {
+		site = defaultDDSite
+	}
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+	host, _ := os.Hostname()
+
+	s := &httpSink{
+		apiKey: apiKey,
+		site:   site,
+		host:   host,
+		client: &http.Client{Timeout: 10 * time.Second},
+		stop:   make(chan struct{}),
+	}
+
+	go s.run(flushInterval)
+	return s
+}
+
+func (s *httpSink) run(flushInterval time.Duration) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.flush()
+		case <-s.stop:
+			s.flush()
+			return
+		}
+	}
+}
+
+// Close stops the flush loop after sending any buffered metrics/events.
+func (s *httpSink) Close() error {
+	close(s.stop)
+	return nil
+}
+
+func (s *httpSink) addSeries(name string, value float64, tags []string, metricType string) error {
+	s.mu.Lock()
+	s.series = append(s.series, seriesMetric{
+		Metric: name,
+		Points: []point{{float64(time.Now().Unix()), value}},
+		Host:   s.host,
+		Tags:   tags,
+		Type:   metricType,
+	})
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *httpSink) Gauge(name string, value float64, tags []string, rate float64) error {
+	return s.addSeries(name, value, tags, "gauge")
+}
+
+func (s *httpSink) Count(name string, value int64, tags []string, rate float64) error {
+	return s.addSeries(name, float64(value), tags, "count")
+}
+
+func (s *httpSink) Histogram(name string, value float64, tags []string, rate float64) error {
+	// The v1 series API has no native histogram type; buffer the raw
+	// samples as gauge points and let Datadog's rollup handle them.
+	return s.addSeries(name, value, tags, "gauge")
+}
+
+func (s *httpSink) Distribution(name string, value float64, tags []string, rate float64) error {
+	// Same reasoning as Histogram: the series API has no distribution
+	// type, so the raw sample is buffered as a gauge point.
+	return s.addSeries(name, value, tags, "gauge")
+}
+
+func (s *httpSink) Event(e *statsd.Event) error {
+	s.mu.Lock()
+	s.events = append(s.events, ddEvent{
+		Title:     e.Title,
+		Text:      e.Text,
+		Tags:      e.Tags,
+		AlertType: string(e.AlertType),
+	})
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *httpSink) flush() {
+	s.mu.Lock()
+	series := s.series
+	events := s.events
+	s.series = nil
+	s.events = nil
+	s.mu.Unlock()
+
+	if len(series) > 0 {
+		if err := s.postWithRetry("/api/v1/series", timeSeries{Series: series}); err != nil {
+			log.WithField("error", err).Warn("Failed to POST series to Datadog API")
+		}
+	}
+	for _, e := range events {
+		if err := s.postWithRetry("/api/v1/events", e); err != nil {
+			log.WithField("error", err).Warn("Failed to POST event to Datadog API")
+		}
+	}
+}
+
+func (s *httpSink) postWithRetry(path string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	if _, err := gz.Write(body); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("https://api.%s%s?api_key=%s", s.site, path, s.apiKey)
+
+	var lastErr error
+	for attempt := 0; attempt < httpSinkMaxRetries; attempt++ {
+		req, err := http.NewRequest("POST", url, bytes.NewReader(gzipped.Bytes()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "gzip")
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			lastErr = err
+			time.Sleep(backoff(attempt))
+			continue
+		}
+		// Drain the body before closing so the underlying connection can
+		// be reused for keep-alive instead of being torn down.
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("datadog api returned status %d", resp.StatusCode)
+		if resp.StatusCode < 500 {
+			// Client errors (bad API key, malformed payload) won't be
+			// fixed by retrying.
+			return lastErr
+		}
+		time.Sleep(backoff(attempt))
+	}
+	return lastErr
+}
+
+func backoff(attempt int) time.Duration {
+	return time.Duration(attempt+1) * 500 * time.Millisecond
+}
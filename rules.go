@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// rulesConfigEnv names the file (YAML or JSON, picked by extension)
+// listing custom log-line extraction rules. See Rule for its shape.
+const rulesConfigEnv = "RULES_CONFIG"
+
+// Rule lets operators turn log lines the built-in message types don't
+// recognize into metrics without patching Go code: Pattern is a regex
+// with named capture groups, one of which (ValueGroup) holds the numeric
+// value and the rest (TagGroups) become tags on the emitted metric. It's
+// matched against reconstructLogLine's "key=value ..." rendering of a
+// message's parsed metrics, so capture groups should target the field
+// names the log-line classifier already extracts.
+type Rule struct {
+	Name       string   `yaml:"name" json:"name"`
+	Pattern    string   `yaml:"pattern" json:"pattern"`
+	MetricName string   `yaml:"metric" json:"metric"`
+	MetricType string   `yaml:"type" json:"type"` // one of sendMetric's types: metric, sample, measure, count
+	ValueGroup string   `yaml:"value_group" json:"value_group"`
+	TagGroups  []string `yaml:"tag_groups" json:"tag_groups"`
+
+	regex *regexp.Regexp
+}
+
+// ruleSet is the set of Rules loaded at startup. A nil *ruleSet (the
+// RULES_CONFIG file failed to load) matches nothing, and neither does the
+// empty ruleSet used when RULES_CONFIG isn't set.
+type ruleSet struct {
+	rules []*Rule
+}
+
+// loadRuleSetFromEnv loads a ruleSet from the file named by RULES_CONFIG,
+// or returns an empty ruleSet if the env var isn't set.
+func loadRuleSetFromEnv() (*ruleSet, error) {
+	path := os.Getenv(rulesConfigEnv)
+	if path == "" {
+		return &ruleSet{}, nil
+	}
+	return loadRuleSet(path)
+}
+
+func loadRuleSet(path string) (*ruleSet, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []*Rule
+	if strings.HasSuffix(path, ".json") {
+		err = json.Unmarshal(raw, &rules)
+	} else {
+		err = yaml.Unmarshal(raw, &rules)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range rules {
+		r.regex, err = regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log.WithFields(log.Fields{"path": path, "count": len(rules)}).Info("Loaded custom metric extraction rules")
+	return &ruleSet{rules: rules}, nil
+}
+
+// match runs line against every rule in order and returns the first
+// match's derived metric name, type, value and tags. ok is false if no
+// rule matched, its value group wasn't captured, or the captured value
+// couldn't be parsed as a float.
+func (rs *ruleSet) match(line string) (name string, metricType string, value float64, tags []string, ok bool) {
+	if rs == nil || line == "" {
+		return "", "", 0, nil, false
+	}
+
+	for _, r := range rs.rules {
+		m := r.regex.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		groups := make(map[string]string, len(m))
+		for i, groupName := range r.regex.SubexpNames() {
+			if i == 0 || groupName == "" {
+				continue
+			}
+			groups[groupName] = m[i]
+		}
+
+		valStr, ok := groups[r.ValueGroup]
+		if !ok {
+			log.WithFields(log.Fields{"rule": r.Name, "group": r.ValueGroup}).Warn("Rule value group not captured")
+			continue
+		}
+		val, err := strconv.ParseFloat(valStr, 64)
+		if err != nil {
+			log.WithFields(log.Fields{"rule": r.Name, "err": err}).Info("Could not parse rule value")
+			continue
+		}
+
+		var ruleTags []string
+		for _, tg := range r.TagGroups {
+			if v, ok := groups[tg]; ok {
+				ruleTags = append(ruleTags, tg+":"+v)
+			}
+		}
+		sort.Strings(ruleTags)
+
+		return r.MetricName, r.MetricType, val, ruleTags, true
+	}
+
+	return "", "", 0, nil, false
+}
+
+// reconstructLogLine rebuilds a logfmt-style "key=value ..." string from
+// an already-parsed message's metrics, for rules to match against.
+// logMetrics doesn't keep the raw logplex line around, so this is built
+// from the one thing every message type already guarantees: its parsed
+// key/value metrics, sorted for deterministic matching.
+func reconstructLogLine(metrics map[string]logValue) string {
+	keys := make([]string, 0, len(metrics))
+	for k := range metrics {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+metrics[k].Val)
+	}
+	return strings.Join(parts, " ")
+}
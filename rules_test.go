@@ -0,0 +1,153 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func newTestRule(t *testing.T, name, pattern, metricName, metricType, valueGroup string, tagGroups []string) *Rule {
+	t.Helper()
+	r := &Rule{
+		Name:       name,
+		Pattern:    pattern,
+		MetricName: metricName,
+		MetricType: metricType,
+		ValueGroup: valueGroup,
+		TagGroups:  tagGroups,
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		t.Fatalf("regexp.Compile(%q): %v", pattern, err)
+	}
+	r.regex = re
+	return r
+}
+
+func TestRuleSetMatch(t *testing.T) {
+	rule := newTestRule(t, "queue-depth",
+		`queue=(?P<queue>\w+) depth=(?P<depth>\d+)`,
+		"worker.queue.depth", "metric", "depth", []string{"queue"})
+
+	cases := []struct {
+		name           string
+		line           string
+		wantOK         bool
+		wantMetricName string
+		wantMetricType string
+		wantValue      float64
+		wantTags       []string
+	}{
+		{
+			name:           "matches and derives value and tags",
+			line:           "at=info queue=mailers depth=12",
+			wantOK:         true,
+			wantMetricName: "worker.queue.depth",
+			wantMetricType: "metric",
+			wantValue:      12,
+			wantTags:       []string{"queue:mailers"},
+		},
+		{
+			name:   "no match",
+			line:   "at=info method=GET path=/",
+			wantOK: false,
+		},
+	}
+
+	rs := &ruleSet{rules: []*Rule{rule}}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			name, metricType, value, tags, ok := rs.match(tc.line)
+			if ok != tc.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tc.wantOK)
+			}
+			if !tc.wantOK {
+				return
+			}
+			if name != tc.wantMetricName {
+				t.Errorf("name = %q, want %q", name, tc.wantMetricName)
+			}
+			if metricType != tc.wantMetricType {
+				t.Errorf("metricType = %q, want %q", metricType, tc.wantMetricType)
+			}
+			if value != tc.wantValue {
+				t.Errorf("value = %v, want %v", value, tc.wantValue)
+			}
+			if !reflect.DeepEqual(tags, tc.wantTags) {
+				t.Errorf("tags = %v, want %v", tags, tc.wantTags)
+			}
+		})
+	}
+}
+
+func TestRuleSetMatchMissingValueGroupSkipsRule(t *testing.T) {
+	// valueGroup references a capture group the pattern doesn't define,
+	// so the rule should be skipped rather than panicking or matching
+	// with a zero value.
+	rule := newTestRule(t, "bad-rule",
+		`queue=(?P<queue>\w+)`,
+		"worker.queue.depth", "metric", "depth", []string{"queue"})
+
+	rs := &ruleSet{rules: []*Rule{rule}}
+
+	_, _, _, _, ok := rs.match("queue=mailers")
+	if ok {
+		t.Fatal("expected match to fail when the value group wasn't captured")
+	}
+}
+
+func TestRuleSetMatchUnparseableValueSkipsRule(t *testing.T) {
+	rule := newTestRule(t, "bad-value",
+		`depth=(?P<depth>\S+)`,
+		"worker.queue.depth", "metric", "depth", nil)
+
+	rs := &ruleSet{rules: []*Rule{rule}}
+
+	_, _, _, _, ok := rs.match("depth=not-a-number")
+	if ok {
+		t.Fatal("expected match to fail when the value group can't be parsed as a float")
+	}
+}
+
+func TestRuleSetMatchTagsAreSorted(t *testing.T) {
+	rule := newTestRule(t, "multi-tag",
+		`queue=(?P<queue>\w+) region=(?P<region>\w+) depth=(?P<depth>\d+)`,
+		"worker.queue.depth", "metric", "depth", []string{"region", "queue"})
+
+	rs := &ruleSet{rules: []*Rule{rule}}
+
+	_, _, _, tags, ok := rs.match("queue=mailers region=us depth=3")
+	if !ok {
+		t.Fatal("expected a match")
+	}
+	want := []string{"queue:mailers", "region:us"}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags = %v, want %v (sorted)", tags, want)
+	}
+}
+
+func TestRuleSetMatchNilRuleSet(t *testing.T) {
+	var rs *ruleSet
+	if _, _, _, _, ok := rs.match("anything"); ok {
+		t.Fatal("expected a nil ruleSet to never match")
+	}
+}
+
+func TestReconstructLogLine(t *testing.T) {
+	metrics := map[string]logValue{
+		"queue": {Val: "mailers"},
+		"depth": {Val: "12"},
+	}
+	got := reconstructLogLine(metrics)
+	want := "depth=12 queue=mailers"
+	if got != want {
+		t.Errorf("reconstructLogLine = %q, want %q", got, want)
+	}
+}
+
+func TestReconstructLogLineEmpty(t *testing.T) {
+	if got := reconstructLogLine(map[string]logValue{}); got != "" {
+		t.Errorf("reconstructLogLine(empty) = %q, want %q", got, "")
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	statsd "github.com/DataDog/datadog-go/statsd"
+)
+
+// MetricSink is the set of statsd.Client methods the drain uses to emit
+// metrics and events. It lets Client fan a measurement out to more than
+// one backend (the local dogstatsd socket, the Datadog HTTP API, ...)
+// without touching the call sites in sendRouterMsg/sendSampleMsg/sendMetric.
+type MetricSink interface {
+	Gauge(name string, value float64, tags []string, rate float64) error
+	Count(name string, value int64, tags []string, rate float64) error
+	Histogram(name string, value float64, tags []string, rate float64) error
+	Distribution(name string, value float64, tags []string, rate float64) error
+	Event(e *statsd.Event) error
+}